@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+// Package opconst defines the wire format of the op stream shared by the op
+// package, its sub-packages such as paint and clip, and the GPU backends
+// that decode it.
+package opconst
+
+// OpType is the type tag of an operation in an op stream, stored in the
+// first byte of its encoding.
+type OpType byte
+
+const (
+	TypeInvalid OpType = iota
+	TypeColor
+	TypeLinearGradient
+	TypeRadialGradient
+	TypeImage
+	TypeMask
+	TypeBlend
+	TypePaint
+)
+
+// The TypeXLen constants are the fixed number of bytes, including the
+// leading type byte, that the corresponding Add method writes into the op
+// stream. Operations with a variable-length payload, such as the
+// gradients' stop lists, size their op.Ops.Write calls directly instead of
+// using one of these constants.
+const (
+	// TypeColorLen is the type byte plus an R, G, B, A byte each.
+	TypeColorLen = 1 + 4
+	// TypeImageLen is the type byte, the source Rect as four uint32,
+	// the pixel format, the Filter and Wrap mode, and the dirty Rect as
+	// four more uint32.
+	TypeImageLen = 1 + 4*4 + 1 + 1 + 1 + 4*4
+	// TypeMaskLen is the type byte plus the mask Rect as four uint32.
+	TypeMaskLen = 1 + 4*4
+	// TypeBlendLen is the type byte plus the blend mode.
+	TypeBlendLen = 1 + 1
+	// TypePaintLen is the type byte plus the destination Rect as four
+	// float32.
+	TypePaintLen = 1 + 4*4
+)
@@ -19,31 +19,205 @@ import (
 //
 // Note: the ImageOp may keep a reference to the backing image.
 // See NewImageOp for details.
+//
+// Note: Filter and Wrap are encoded onto the wire, but GPU backend sampler
+// state to honor them is tracked separately and does not exist yet; until
+// it lands, Rect is always stretched to cover the PaintOp's Rect with
+// linear filtering, regardless of these fields.
 type ImageOp struct {
-	// Rect is the section if the backing image to use.
+	// Rect is the source subrect of the backing image to use. It is
+	// sampled according to Filter and, if it doesn't cover the PaintOp's
+	// Rect, tiled according to Wrap.
 	Rect image.Rectangle
+	// Filter is the sampling filter to use when Rect doesn't match the
+	// destination size. The zero value is FilterNearest.
+	Filter Filter
+	// Wrap controls how Rect is sampled outside its bounds. The zero
+	// value is WrapClamp.
+	Wrap WrapMode
 
 	uniform bool
 	color   color.RGBA
-	src     *image.RGBA
+	src     image.Image
+	format  imageFormat
 
 	// handle is a key to uniquely identify this ImageOp
 	// in a map of cached textures.
 	handle interface{}
+
+	// dirty is the subregion of src that changed since handle was last
+	// uploaded. It is empty for ordinary, single-use ImageOps, where a
+	// cache miss on handle already triggers a full upload; ImageSource
+	// sets it to request a partial re-upload of a texture it keeps
+	// across frames.
+	dirty image.Rectangle
 }
 
+// Filter selects the sampling filter used when an ImageOp's Rect doesn't
+// match the size of the destination PaintOp.Rect.
+type Filter uint8
+
+const (
+	// FilterNearest selects the nearest source pixel, suited to pixel art.
+	// This is the default filter.
+	FilterNearest Filter = iota
+	// FilterLinear linearly interpolates between source pixels, suited to
+	// smooth scaling.
+	FilterLinear
+)
+
+// WrapMode selects how an ImageOp is sampled outside its Rect.
+type WrapMode uint8
+
+const (
+	// WrapClamp clamps to the edge pixel of Rect. This is the default
+	// wrap mode.
+	WrapClamp WrapMode = iota
+	// WrapRepeat tiles Rect across the destination.
+	WrapRepeat
+	// WrapMirror tiles Rect across the destination, mirroring every
+	// other repetition.
+	WrapMirror
+)
+
+// imageFormat identifies the pixel layout of an ImageOp's backing image, so
+// that the GPU backend can upload it directly instead of converting it to
+// premultiplied RGBA on the CPU first.
+type imageFormat byte
+
+const (
+	// formatRGBA is tightly packed, premultiplied RGBA as produced by
+	// image.NewRGBA and draw.Draw.
+	formatRGBA imageFormat = iota
+	// formatNRGBA is tightly packed, unpremultiplied RGBA.
+	formatNRGBA
+	// formatGray is a single 8-bit luminance channel, uploaded as an
+	// R8 texture.
+	formatGray
+	// formatYCbCr is the planar format produced by JPEG decoding; the
+	// fragment shader performs the YCbCr to RGB conversion.
+	formatYCbCr
+	// formatPaletted is an 8-bit paletted image; the palette is uploaded
+	// alongside the index texture.
+	formatPaletted
+	// formatRGBA64 is a generic image.RGBA64Image, read through its
+	// RGBA64At method.
+	formatRGBA64
+)
+
 // ColorOp sets the brush to a constant color.
 type ColorOp struct {
 	Color color.RGBA
 }
 
-// LinearGradientOp sets the brush to a gradient starting at stop1 with color1 and
-// ending at stop2 with color2.
+// MaskOp sets a coverage mask that modulates the alpha of the next PaintOp,
+// in addition to the current clip.Op. It allows layout and widget code to
+// paint free-form soft masks, such as shadows or cached anti-aliased
+// coverage from a software rasterizer, without approximating them as clip
+// paths.
+//
+// MaskOp stacks like ColorOp and the gradient ops: it applies to PaintOps
+// added until the enclosing op.Push is popped.
+//
+// Note: MaskOp defines opconst.TypeMask and its wire format, but GPU
+// backend decoding of that opcode and the R8/A8 texture upload path it
+// reuses from ImageOp are tracked separately and do not exist yet; a
+// MaskOp has no visible effect until they do.
+type MaskOp struct {
+	// Rect is the section of the backing image to use as the mask.
+	Rect image.Rectangle
+
+	src *image.Alpha
+
+	// handle is a key to uniquely identify this MaskOp in a map of
+	// cached textures.
+	handle interface{}
+}
+
+// NewMaskOp creates a MaskOp backed by src. See NewImageOp for a description
+// of when data referenced by operations is safe to re-use; the same rules
+// apply to src here.
+func NewMaskOp(src *image.Alpha) MaskOp {
+	return MaskOp{
+		Rect:   src.Bounds(),
+		src:    src,
+		handle: new(int),
+	}
+}
+
+// GradientStop represents a single color stop in a multi-stop gradient. The
+// stops of a gradient must be in ascending Offset order, with Offset ranging
+// from 0 to 1.
+type GradientStop struct {
+	Offset float32
+	Color  color.RGBA
+}
+
+// SpreadMethod describes how a gradient is extended beyond its defined
+// [0, 1] offset range.
+type SpreadMethod uint8
+
+const (
+	// SpreadPad clamps to the color of the nearest stop.
+	SpreadPad SpreadMethod = iota
+	// SpreadRepeat repeats the gradient.
+	SpreadRepeat
+	// SpreadReflect mirrors the gradient back and forth.
+	SpreadReflect
+)
+
+// gradientStopSize is the encoded size, in bytes, of a single GradientStop.
+const gradientStopSize = 4 + 4
+
+// maxGradientStops is the largest number of stops that fits in the 1-byte
+// stop count written by LinearGradientOp.Add and RadialGradientOp.Add.
+const maxGradientStops = 255
+
+// linearGradientHeaderLen is the encoded size, in bytes, of a
+// LinearGradientOp excluding its Stops: the type byte, Stop1 and Stop2 as
+// four float32, the spread mode, and the stop count.
+const linearGradientHeaderLen = 1 + 4*4 + 1 + 1
+
+// radialGradientHeaderLen is the encoded size, in bytes, of a
+// RadialGradientOp excluding its Stops: the type byte, Center, Radius and
+// Focal as five float32, the spread mode, and the stop count.
+const radialGradientHeaderLen = 1 + 4*5 + 1 + 1
+
+// LinearGradientOp sets the brush to a gradient starting at Stop1 and ending
+// at Stop2, interpolating piecewise linearly in premultiplied color space
+// between Stops. If Stops is empty, LinearGradientOp falls back to a
+// two-stop gradient from Color1 at offset 0 to Color2 at offset 1, as
+// before multi-stop support was added.
+//
+// Note: multi-stop and spread-mode decoding in the GPU backend is tracked
+// separately; until it lands, the wire format is fixed but only the first
+// and last Stop are honored, as with the prior two-color gradient.
 type LinearGradientOp struct {
-	Stop1  f32.Point
+	Stop1 f32.Point
+	Stop2 f32.Point
+
+	// Color1 and Color2 are used in place of Stops when Stops is empty.
 	Color1 color.RGBA
-	Stop2  f32.Point
 	Color2 color.RGBA
+
+	Stops  []GradientStop
+	Spread SpreadMethod
+}
+
+// RadialGradientOp sets the brush to a gradient radiating from Focal out to
+// the circle centered at Center with radius Radius, interpolating piecewise
+// linearly in premultiplied color space between Stops.
+//
+// Note: RadialGradientOp defines opconst.TypeRadialGradient and its wire
+// format, but GPU backend decoding of that opcode is tracked separately and
+// does not exist yet; adding a RadialGradientOp has no visible effect until
+// it does.
+type RadialGradientOp struct {
+	Center f32.Point
+	Radius float32
+	Focal  f32.Point
+	Stops  []GradientStop
+	Spread SpreadMethod
 }
 
 // PaintOp fills an area with the current brush, respecting the
@@ -54,6 +228,50 @@ type PaintOp struct {
 	Rect f32.Rectangle
 }
 
+// BlendMode selects the compositing operator used by PaintOp to combine its
+// source color with the destination.
+type BlendMode uint8
+
+const (
+	// Over composites the source over the destination. This is the
+	// default blend mode.
+	Over BlendMode = iota
+	// Src replaces the destination with the source, ignoring it
+	// entirely.
+	Src
+	// DstOver composites the destination over the source.
+	DstOver
+	// DstOut keeps the destination where the source is transparent.
+	DstOut
+	// SrcIn keeps the source where the destination is opaque.
+	SrcIn
+	// SrcOut keeps the source where the destination is transparent.
+	SrcOut
+	// Xor combines source and destination, keeping each where the
+	// other is transparent.
+	Xor
+	// Multiply multiplies source and destination colors.
+	Multiply
+	// Screen is the inverse of Multiply.
+	Screen
+	// Plus adds source and destination colors.
+	Plus
+)
+
+// BlendOp sets the blend mode used by subsequent PaintOps, until the
+// enclosing op.Push is popped. The default blend mode is Over.
+//
+// Modes that fixed-function GPU blending cannot express are implemented
+// with a destination-read pass in the backend.
+//
+// Note: BlendOp defines opconst.TypeBlend and its wire format, but GPU
+// backend decoding and the per-mode blend-state/destination-read support
+// are tracked separately and do not exist yet; until they land, all
+// PaintOps composite source-over regardless of the enclosing BlendOp.
+type BlendOp struct {
+	Mode BlendMode
+}
+
 // NewImageOp creates an ImageOp backed by src. See
 // gioui.org/io/system.FrameEvent for a description of when data
 // referenced by operations is safe to re-use.
@@ -62,23 +280,19 @@ type PaintOp struct {
 // copy of its contents in a GPU-friendly way. Create new ImageOps to
 // ensure that changes to an image is reflected in the display of
 // it.
+//
+// NewImageOp recognizes several common image.Image implementations
+// and uploads their pixels to the GPU directly, without first copying
+// them into a fresh *image.RGBA. Other image types fall back to a
+// CPU-side conversion, as before.
+//
+// Note: the GPU backend's upload path for the non-RGBA formats tagged here
+// (imageFormat) is tracked separately and does not exist yet; until it
+// lands, images taking one of the new fast paths are not displayed
+// correctly.
 func NewImageOp(src image.Image) ImageOp {
-	switch src := src.(type) {
-	case *image.Uniform:
-		col := color.RGBAModel.Convert(src.C).(color.RGBA)
-		return ImageOp{
-			uniform: true,
-			color:   col,
-		}
-	case *image.RGBA:
-		bounds := src.Bounds()
-		if bounds.Min == (image.Point{}) && src.Stride == bounds.Dx()*4 {
-			return ImageOp{
-				Rect:   src.Bounds(),
-				src:    src,
-				handle: new(int),
-			}
-		}
+	if op, ok := fastImageOp(src); ok {
+		return op
 	}
 
 	sz := src.Bounds().Size()
@@ -90,10 +304,77 @@ func NewImageOp(src image.Image) ImageOp {
 	return ImageOp{
 		Rect:   dst.Bounds(),
 		src:    dst,
+		format: formatRGBA,
 		handle: new(int),
 	}
 }
 
+// fastImageOp returns an ImageOp that uses src without a CPU-side copy, for
+// the image.Image implementations NewImageOp recognizes: it shares src's
+// backing storage, or, for an *image.Uniform, keeps only its color. It
+// reports whether src qualified.
+func fastImageOp(src image.Image) (ImageOp, bool) {
+	switch src := src.(type) {
+	case *image.Uniform:
+		col := color.RGBAModel.Convert(src.C).(color.RGBA)
+		return ImageOp{
+			uniform: true,
+			color:   col,
+		}, true
+	case *image.RGBA:
+		if bounds := src.Bounds(); bounds.Min == (image.Point{}) && src.Stride == bounds.Dx()*4 {
+			return ImageOp{
+				Rect:   bounds,
+				src:    src,
+				format: formatRGBA,
+				handle: new(int),
+			}, true
+		}
+	case *image.NRGBA:
+		if bounds := src.Bounds(); bounds.Min == (image.Point{}) && src.Stride == bounds.Dx()*4 {
+			return ImageOp{
+				Rect:   bounds,
+				src:    src,
+				format: formatNRGBA,
+				handle: new(int),
+			}, true
+		}
+	case *image.Gray:
+		if bounds := src.Bounds(); bounds.Min == (image.Point{}) && src.Stride == bounds.Dx() {
+			return ImageOp{
+				Rect:   bounds,
+				src:    src,
+				format: formatGray,
+				handle: new(int),
+			}, true
+		}
+	case *image.Paletted:
+		if bounds := src.Bounds(); bounds.Min == (image.Point{}) && src.Stride == bounds.Dx() {
+			return ImageOp{
+				Rect:   bounds,
+				src:    src,
+				format: formatPaletted,
+				handle: new(int),
+			}, true
+		}
+	case *image.YCbCr:
+		return ImageOp{
+			Rect:   src.Bounds(),
+			src:    src,
+			format: formatYCbCr,
+			handle: new(int),
+		}, true
+	case image.RGBA64Image:
+		return ImageOp{
+			Rect:   src.Bounds(),
+			src:    src,
+			format: formatRGBA64,
+			handle: new(int),
+		}, true
+	}
+	return ImageOp{}, false
+}
+
 func (i ImageOp) Size() image.Point {
 	if i.src == nil {
 		return image.Point{}
@@ -115,6 +396,106 @@ func (i ImageOp) Add(o *op.Ops) {
 	bo.PutUint32(data[5:], uint32(i.Rect.Min.Y))
 	bo.PutUint32(data[9:], uint32(i.Rect.Max.X))
 	bo.PutUint32(data[13:], uint32(i.Rect.Max.Y))
+	data[17] = byte(i.format)
+	data[18] = byte(i.Filter)
+	data[19] = byte(i.Wrap)
+	bo.PutUint32(data[20:], uint32(i.dirty.Min.X))
+	bo.PutUint32(data[24:], uint32(i.dirty.Min.Y))
+	bo.PutUint32(data[28:], uint32(i.dirty.Max.X))
+	bo.PutUint32(data[32:], uint32(i.dirty.Max.Y))
+}
+
+// ImageSource is a mutable, GPU-backed image, for callers with content that
+// changes from frame to frame, such as a YCbCr-decoding video player or a
+// canvas-style widget. Unlike NewImageOp, which assumes its backing image
+// is immutable and allocates a fresh handle to get a fresh texture,
+// ImageSource keeps one texture handle alive across frames and only
+// re-uploads the rows marked dirty by Invalidate.
+//
+// Note: the GPU texture cache changes needed to honor op.dirty on a reused
+// handle, rather than re-uploading the whole image on every Op, are tracked
+// separately and do not exist yet.
+type ImageSource struct {
+	img    image.Image
+	handle interface{}
+	dirty  image.Rectangle
+
+	// dst caches the CPU-converted buffer used for images that don't
+	// qualify for one of NewImageOp's zero-copy fast paths, so that Op
+	// doesn't allocate a fresh buffer on every call.
+	dst *image.RGBA
+}
+
+// NewImageSource creates an ImageSource backed by img. The caller retains
+// ownership of img and may mutate its pixels in place between frames; call
+// Invalidate to mark the mutated region for re-upload.
+func NewImageSource(img image.Image) *ImageSource {
+	return &ImageSource{
+		img:    img,
+		handle: new(int),
+		dirty:  img.Bounds(),
+	}
+}
+
+// Invalidate marks r, intersected with the image's bounds, as changed since
+// the last Op and in need of re-upload. Calling Invalidate more than once
+// between calls to Op enlarges the dirty region to their union.
+func (s *ImageSource) Invalidate(r image.Rectangle) {
+	r = r.Intersect(s.img.Bounds())
+	if r.Empty() {
+		return
+	}
+	if s.dirty.Empty() {
+		s.dirty = r
+	} else {
+		s.dirty = s.dirty.Union(r)
+	}
+}
+
+// Op returns an ImageOp painting the current contents of s. The ImageOp
+// carries the region marked dirty since the previous Op, for the backend to
+// re-upload (see the note on ImageSource); Op then clears the dirty region.
+func (s *ImageSource) Op() ImageOp {
+	op := s.imageOp()
+	op.handle = s.handle
+	// NewImageOp's fast paths preserve the source's Bounds().Min in
+	// op.Rect, but its CPU-conversion fallback always returns a
+	// zero-origin image. s.dirty is tracked in s.img's coordinate space,
+	// so translate it into op.Rect's space to match.
+	op.dirty = s.dirty.Add(op.Rect.Min.Sub(s.img.Bounds().Min))
+	s.dirty = image.Rectangle{}
+	return op
+}
+
+// imageOp is like NewImageOp(s.img), but reuses s.dst across calls for
+// images that don't qualify for one of NewImageOp's zero-copy fast paths,
+// so that repainting an unchanging or partially-changing s.img doesn't
+// allocate a fresh conversion buffer every frame.
+func (s *ImageSource) imageOp() ImageOp {
+	if op, ok := fastImageOp(s.img); ok {
+		return op
+	}
+	sz := s.img.Bounds().Size()
+	if s.dst == nil || s.dst.Bounds().Size() != sz {
+		s.dst = image.NewRGBA(image.Rectangle{Max: sz})
+	}
+	draw.Draw(s.dst, s.dst.Bounds(), s.img, s.img.Bounds().Min, draw.Src)
+	return ImageOp{
+		Rect:   s.dst.Bounds(),
+		src:    s.dst,
+		format: formatRGBA,
+		handle: new(int),
+	}
+}
+
+func (m MaskOp) Add(o *op.Ops) {
+	data := o.Write(opconst.TypeMaskLen, m.src, m.handle)
+	data[0] = byte(opconst.TypeMask)
+	bo := binary.LittleEndian
+	bo.PutUint32(data[1:], uint32(m.Rect.Min.X))
+	bo.PutUint32(data[5:], uint32(m.Rect.Min.Y))
+	bo.PutUint32(data[9:], uint32(m.Rect.Max.X))
+	bo.PutUint32(data[13:], uint32(m.Rect.Max.Y))
 }
 
 func (c ColorOp) Add(o *op.Ops) {
@@ -126,8 +507,24 @@ func (c ColorOp) Add(o *op.Ops) {
 	data[4] = c.Color.A
 }
 
+// stopsOrColors returns c.Stops, or a two-stop gradient from Color1 to
+// Color2 if c.Stops is empty, preserving the pre-multi-stop API.
+func (c LinearGradientOp) stopsOrColors() []GradientStop {
+	if len(c.Stops) > 0 {
+		return c.Stops
+	}
+	return []GradientStop{
+		{Offset: 0, Color: c.Color1},
+		{Offset: 1, Color: c.Color2},
+	}
+}
+
 func (c LinearGradientOp) Add(o *op.Ops) {
-	data := o.Write(opconst.TypeLinearGradientLen)
+	stops := c.stopsOrColors()
+	if len(stops) > maxGradientStops {
+		panic("paint: too many gradient stops")
+	}
+	data := o.Write(linearGradientHeaderLen + len(stops)*gradientStopSize)
 	data[0] = byte(opconst.TypeLinearGradient)
 
 	bo := binary.LittleEndian
@@ -135,15 +532,47 @@ func (c LinearGradientOp) Add(o *op.Ops) {
 	bo.PutUint32(data[5:], math.Float32bits(c.Stop1.Y))
 	bo.PutUint32(data[9:], math.Float32bits(c.Stop2.X))
 	bo.PutUint32(data[13:], math.Float32bits(c.Stop2.Y))
+	data[17] = byte(c.Spread)
+	data[18] = byte(len(stops))
+	encodeGradientStops(data[19:], stops)
+}
+
+func (c RadialGradientOp) Add(o *op.Ops) {
+	if len(c.Stops) > maxGradientStops {
+		panic("paint: too many gradient stops")
+	}
+	data := o.Write(radialGradientHeaderLen + len(c.Stops)*gradientStopSize)
+	data[0] = byte(opconst.TypeRadialGradient)
+
+	bo := binary.LittleEndian
+	bo.PutUint32(data[1:], math.Float32bits(c.Center.X))
+	bo.PutUint32(data[5:], math.Float32bits(c.Center.Y))
+	bo.PutUint32(data[9:], math.Float32bits(c.Radius))
+	bo.PutUint32(data[13:], math.Float32bits(c.Focal.X))
+	bo.PutUint32(data[17:], math.Float32bits(c.Focal.Y))
+	data[21] = byte(c.Spread)
+	data[22] = byte(len(c.Stops))
+	encodeGradientStops(data[23:], c.Stops)
+}
+
+// encodeGradientStops writes stops into data, which must be at least
+// len(stops)*gradientStopSize bytes.
+func encodeGradientStops(data []byte, stops []GradientStop) {
+	bo := binary.LittleEndian
+	for i, s := range stops {
+		d := data[i*gradientStopSize:]
+		bo.PutUint32(d, math.Float32bits(s.Offset))
+		d[4+0] = s.Color.R
+		d[4+1] = s.Color.G
+		d[4+2] = s.Color.B
+		d[4+3] = s.Color.A
+	}
+}
 
-	data[17+0] = c.Color1.R
-	data[17+1] = c.Color1.G
-	data[17+2] = c.Color1.B
-	data[17+3] = c.Color1.A
-	data[21+0] = c.Color2.R
-	data[21+1] = c.Color2.G
-	data[21+2] = c.Color2.B
-	data[21+3] = c.Color2.A
+func (b BlendOp) Add(o *op.Ops) {
+	data := o.Write(opconst.TypeBlendLen)
+	data[0] = byte(opconst.TypeBlend)
+	data[1] = byte(b.Mode)
 }
 
 func (d PaintOp) Add(o *op.Ops) {
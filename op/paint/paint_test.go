@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package paint
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeGradientStops(t *testing.T) {
+	stops := []GradientStop{
+		{Offset: 0, Color: color.RGBA{R: 0xff, A: 0xff}},
+		{Offset: 0.5, Color: color.RGBA{G: 0xff, A: 0x80}},
+	}
+	data := make([]byte, len(stops)*gradientStopSize)
+	encodeGradientStops(data, stops)
+
+	for i, s := range stops {
+		d := data[i*gradientStopSize:]
+		bo := uint32(d[0]) | uint32(d[1])<<8 | uint32(d[2])<<16 | uint32(d[3])<<24
+		if got := math.Float32frombits(bo); got != s.Offset {
+			t.Errorf("stop %d: Offset = %v, want %v", i, got, s.Offset)
+		}
+		if got := (color.RGBA{R: d[4], G: d[5], B: d[6], A: d[7]}); got != s.Color {
+			t.Errorf("stop %d: Color = %v, want %v", i, got, s.Color)
+		}
+	}
+}
+
+func TestLinearGradientOpTooManyStops(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Add did not panic with too many stops")
+		}
+	}()
+	op := LinearGradientOp{Stops: make([]GradientStop, maxGradientStops+1)}
+	op.Add(nil)
+}
+
+func TestLinearGradientOpColorFallback(t *testing.T) {
+	// With Stops empty, Add must encode a two-stop gradient from
+	// Color1/Color2, preserving the pre-multi-stop API.
+	red := color.RGBA{R: 0xff, A: 0xff}
+	blue := color.RGBA{B: 0xff, A: 0xff}
+	c := LinearGradientOp{Color1: red, Color2: blue}
+	want := []GradientStop{
+		{Offset: 0, Color: red},
+		{Offset: 1, Color: blue},
+	}
+	if got := c.stopsOrColors(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stopsOrColors() = %v, want %v", got, want)
+	}
+
+	// Stops, when set, takes priority over Color1/Color2.
+	c.Stops = []GradientStop{{Offset: 0.5, Color: red}}
+	if got := c.stopsOrColors(); !reflect.DeepEqual(got, c.Stops) {
+		t.Errorf("stopsOrColors() = %v, want %v", got, c.Stops)
+	}
+}
+
+func TestRadialGradientOpTooManyStops(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Add did not panic with too many stops")
+		}
+	}()
+	op := RadialGradientOp{Stops: make([]GradientStop, maxGradientStops+1)}
+	op.Add(nil)
+}
+
+// plainImage implements only image.Image, taking NewImageOp's generic
+// CPU-conversion fallback regardless of what any format-specific or
+// RGBA64Image fast path would otherwise apply.
+type plainImage struct {
+	r image.Rectangle
+}
+
+func (p plainImage) ColorModel() color.Model { return color.RGBAModel }
+func (p plainImage) Bounds() image.Rectangle { return p.r }
+func (p plainImage) At(x, y int) color.Color { return color.RGBA{} }
+
+func TestNewImageOpFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  image.Image
+		want imageFormat
+	}{
+		{"RGBA", image.NewRGBA(image.Rect(0, 0, 2, 2)), formatRGBA},
+		{"NRGBA", image.NewNRGBA(image.Rect(0, 0, 2, 2)), formatNRGBA},
+		{"Gray", image.NewGray(image.Rect(0, 0, 2, 2)), formatGray},
+		{"Paletted", image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White}), formatPaletted},
+		{"YCbCr", image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio420), formatYCbCr},
+		{"plain fallback", plainImage{image.Rect(0, 0, 2, 2)}, formatRGBA},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			op := NewImageOp(tc.src)
+			if op.format != tc.want {
+				t.Errorf("NewImageOp(%T).format = %v, want %v", tc.src, op.format, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewImageOpNonZeroOriginFallback(t *testing.T) {
+	// A sub-image with a non-zero Min and a non-tight stride takes the
+	// CPU-conversion fallback, which always returns a zero-origin image.
+	base := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	sub := base.SubImage(image.Rect(1, 1, 3, 3)).(*image.RGBA)
+	op := NewImageOp(sub)
+	if op.Rect.Min != (image.Point{}) {
+		t.Errorf("Rect.Min = %v, want zero", op.Rect.Min)
+	}
+}
+
+func TestImageSourceInvalidate(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	s := NewImageSource(img)
+
+	// The initial dirty region covers the whole image.
+	first := s.Op()
+	if first.dirty != img.Bounds() {
+		t.Fatalf("initial dirty = %v, want %v", first.dirty, img.Bounds())
+	}
+
+	// Op clears the dirty region.
+	second := s.Op()
+	if !second.dirty.Empty() {
+		t.Fatalf("dirty after Op = %v, want empty", second.dirty)
+	}
+
+	// Successive Invalidate calls accumulate their union.
+	s.Invalidate(image.Rect(1, 1, 3, 3))
+	s.Invalidate(image.Rect(5, 5, 7, 7))
+	want := image.Rect(1, 1, 3, 3).Union(image.Rect(5, 5, 7, 7))
+	third := s.Op()
+	if third.dirty != want {
+		t.Fatalf("accumulated dirty = %v, want %v", third.dirty, want)
+	}
+
+	// Invalidate clips to the image bounds.
+	s.Invalidate(image.Rect(-5, -5, 20, 20))
+	fourth := s.Op()
+	if fourth.dirty != img.Bounds() {
+		t.Fatalf("clipped dirty = %v, want %v", fourth.dirty, img.Bounds())
+	}
+}
+
+func TestImageSourceReusesConversionBuffer(t *testing.T) {
+	// plainImage never hits a fast path, so every Op must go through the
+	// CPU conversion; ImageSource should reuse one destination buffer
+	// across calls instead of allocating a fresh one each time.
+	img := plainImage{image.Rect(0, 0, 4, 4)}
+	s := NewImageSource(img)
+
+	first := s.Op()
+	firstDst := s.dst
+	if firstDst == nil {
+		t.Fatal("imageOp did not populate s.dst for a fallback image")
+	}
+	if first.src != firstDst {
+		t.Fatal("Op's ImageOp does not reference the cached buffer")
+	}
+
+	s.Invalidate(image.Rect(0, 0, 1, 1))
+	second := s.Op()
+	if s.dst != firstDst {
+		t.Error("Op allocated a new buffer for an unchanged image size")
+	}
+	if second.src != firstDst {
+		t.Error("second Op's ImageOp does not reference the cached buffer")
+	}
+
+	// A change in image size must still trigger reallocation.
+	s.img = plainImage{image.Rect(0, 0, 8, 8)}
+	s.Invalidate(s.img.Bounds())
+	third := s.Op()
+	if s.dst == firstDst {
+		t.Error("Op did not reallocate the buffer after the image size changed")
+	}
+	if third.src != s.dst {
+		t.Error("third Op's ImageOp does not reference the new cached buffer")
+	}
+}
+
+func TestImageSourceInvalidateFallbackCoordinates(t *testing.T) {
+	// A non-zero-origin backing image takes NewImageOp's CPU-conversion
+	// fallback, which remaps pixels onto a zero-origin buffer. The dirty
+	// rect handed to backends must be translated into that new space.
+	base := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	img := base.SubImage(image.Rect(2, 2, 8, 8)).(*image.RGBA)
+	s := NewImageSource(img)
+	s.Op() // drain the initial whole-image dirty region
+	s.Invalidate(image.Rect(3, 3, 5, 5))
+
+	op := s.Op()
+	if op.Rect.Min != (image.Point{}) {
+		t.Fatalf("Rect.Min = %v, want zero (fallback path)", op.Rect.Min)
+	}
+	want := image.Rect(3, 3, 5, 5).Sub(img.Bounds().Min)
+	if op.dirty != want {
+		t.Fatalf("dirty = %v, want %v", op.dirty, want)
+	}
+	if !op.dirty.In(op.Rect) {
+		t.Fatalf("dirty %v is not contained in Rect %v", op.dirty, op.Rect)
+	}
+}